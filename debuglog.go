@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// only this much of a response body is kept for the debug log
+const debugLogMaxBody = 64 << 10
+
+// where debug log lines go, tests swap it for a buffer
+var debugLogOut io.Writer = os.Stdout
+
+// debugLogFilter is DEBUG_LOG_FILTER, a query param matcher like "id=5". Requests it matches get their
+// params and full response body logged, with the DEBUG_LOG_REDACT fields (comma separated) masked.
+type debugLogFilter struct {
+	param  string
+	value  string
+	redact map[string]bool
+}
+
+func parseDebugLogFilter(filter, redact string) (*debugLogFilter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+	param, value, ok := strings.Cut(filter, "=")
+	if !ok || param == "" || value == "" {
+		// an empty value would match every request that doesn't send the param at all
+		return nil, fmt.Errorf("expected param=value with a non-empty value, got %q", filter)
+	}
+	f := &debugLogFilter{param: param, value: value, redact: make(map[string]bool)}
+	for _, field := range strings.Split(redact, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			f.redact[field] = true
+		}
+	}
+	return f, nil
+}
+
+// bodyRecorder passes writes through to the client and keeps a copy of the first debugLogMaxBody bytes.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool // the body was longer than debugLogMaxBody
+}
+
+func (b *bodyRecorder) WriteHeader(status int) {
+	b.status = status
+	b.ResponseWriter.WriteHeader(status)
+}
+
+func (b *bodyRecorder) Write(p []byte) (int, error) {
+	room := debugLogMaxBody - b.body.Len()
+	if len(p) > room {
+		b.truncated = true
+	}
+	if room > 0 {
+		b.body.Write(p[:min(len(p), room)])
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+func withDebugLog(f *debugLogFilter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(f.param) != f.value {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		fmt.Fprintf(debugLogOut, "DEBUG %s %s params=%s status=%d body=%s\n",
+			r.Method, r.URL.Path, f.redactParams(r.URL.Query()), rec.status, f.redactBody(rec.body.Bytes(), rec.truncated))
+	})
+}
+
+func (f *debugLogFilter) redactParams(params url.Values) string {
+	masked := url.Values{}
+	for k, v := range params {
+		if f.redact[k] {
+			masked[k] = []string{"[REDACTED]"}
+		} else {
+			masked[k] = v
+		}
+	}
+	return masked.Encode()
+}
+
+// redactBody masks redacted fields anywhere in a JSON body. When redaction is on, a body that was cut off
+// at debugLogMaxBody or isn't JSON can't be checked for those fields, so it isn't logged at all.
+func (f *debugLogFilter) redactBody(body []byte, truncated bool) string {
+	if len(f.redact) == 0 {
+		s := strings.TrimSpace(string(body))
+		if truncated {
+			s += "...[truncated]"
+		}
+		return s
+	}
+	if truncated {
+		return "[body not logged: truncated]"
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "[body not logged: not JSON]"
+	}
+	masked, _ := json.Marshal(f.redactValue(v))
+	return string(masked)
+}
+
+func (f *debugLogFilter) redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if f.redact[k] {
+				v[k] = "[REDACTED]"
+			} else {
+				v[k] = f.redactValue(child)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = f.redactValue(child)
+		}
+	}
+	return v
+}
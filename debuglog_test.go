@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseDebugLogFilter(t *testing.T) {
+	f, err := parseDebugLogFilter("id=5", "secret, name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.param != "id" || f.value != "5" || !f.redact["secret"] || !f.redact["name"] {
+		t.Errorf("got %+v", f)
+	}
+
+	if f, err := parseDebugLogFilter("", ""); f != nil || err != nil {
+		t.Errorf("empty filter should be off, got %v, %v", f, err)
+	}
+	for _, bad := range []string{"id", "=5", "id="} {
+		if _, err := parseDebugLogFilter(bad, ""); err == nil {
+			t.Errorf("%q should be rejected", bad)
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	f, _ := parseDebugLogFilter("id=1", "name")
+
+	got := f.redactBody([]byte(`[{"itemId":"1","name":"Maple"}]`), false)
+	if got != `[{"itemId":"1","name":"[REDACTED]"}]` {
+		t.Errorf("redacted body = %s", got)
+	}
+	if got := f.redactBody([]byte(`[{"itemId":"1","name":"Ma`), true); got != "[body not logged: truncated]" {
+		t.Errorf("truncated body = %s", got)
+	}
+	if got := f.redactBody([]byte("Donut not found\n"), false); got != "[body not logged: not JSON]" {
+		t.Errorf("plain text body = %s", got)
+	}
+}
+
+func TestDebugLogOnlyMatchingRequests(t *testing.T) {
+	var out bytes.Buffer
+	setGlobal[io.Writer](t, &debugLogOut, &out)
+
+	f, _ := parseDebugLogFilter("id=1", "name")
+	h := withDebugLog(f, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"itemId":"` + r.URL.Query().Get("id") + `","name":"Maple"}`))
+	}))
+
+	for _, target := range []string{"/donuts?id=2", "/donuts", "/donuts?id=1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("want exactly one debug line, got %q", out.String())
+	}
+	if !strings.Contains(lines[0], `"itemId":"1"`) || strings.Contains(lines[0], "Maple") {
+		t.Errorf("debug line = %s, want the id=1 body with name redacted", lines[0])
+	}
+}
+
+func TestDebugLogLargeBodyNotLeaked(t *testing.T) {
+	var out bytes.Buffer
+	setGlobal[io.Writer](t, &debugLogOut, &out)
+
+	f, _ := parseDebugLogFilter("id=1", "name")
+	big := `[` + strings.Repeat(`{"name":"Maple"},`, debugLogMaxBody/16) + `{"name":"Maple"}]`
+	h := withDebugLog(f, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(big))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/all_donuts?id=1", nil))
+
+	if strings.Contains(out.String(), "Maple") || !strings.Contains(out.String(), "[body not logged: truncated]") {
+		t.Errorf("large body should not be logged, got %.200s", out.String())
+	}
+}
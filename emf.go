@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// METRICS_FORMAT=emf prints CloudWatch Embedded Metric Format lines to stdout. On Fargate the awslogs driver
+// ships stdout to CloudWatch Logs, which turns these lines into metrics without running an agent.
+var emfEnabled bool
+
+const emfNamespace = "PDCApp"
+
+// where EMF lines go, tests swap it for a buffer
+var emfOut io.Writer = os.Stdout
+
+type emfMetric struct {
+	Name  string
+	Unit  string // CloudWatch unit, e.g. Count or Milliseconds
+	Value float64
+}
+
+// emitEMF prints one EMF blob with the given dimensions and metrics, if EMF is enabled.
+func emitEMF(dimensions map[string]string, metrics ...emfMetric) {
+	if !emfEnabled {
+		return
+	}
+
+	dimNames := make([]string, 0, len(dimensions))
+	blob := make(map[string]any, len(dimensions)+len(metrics)+1)
+	for name, value := range dimensions {
+		dimNames = append(dimNames, name)
+		blob[name] = value
+	}
+
+	defs := make([]map[string]string, 0, len(metrics))
+	for _, m := range metrics {
+		defs = append(defs, map[string]string{"Name": m.Name, "Unit": m.Unit})
+		blob[m.Name] = m.Value
+	}
+
+	blob["_aws"] = map[string]any{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]any{{
+			"Namespace":  emfNamespace,
+			"Dimensions": [][]string{dimNames},
+			"Metrics":    defs,
+		}},
+	}
+
+	line, err := json.Marshal(blob)
+	if err != nil {
+		fmt.Printf("Failed to encode EMF metrics: %v\n", err)
+		return
+	}
+	fmt.Fprintln(emfOut, string(line))
+}
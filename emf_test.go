@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmitEMF(t *testing.T) {
+	var out bytes.Buffer
+	setGlobal[io.Writer](t, &emfOut, &out)
+
+	setGlobal(t, &emfEnabled, false)
+	emitEMF(map[string]string{"Path": "/donuts"}, emfMetric{Name: "Requests", Unit: "Count", Value: 1})
+	if out.Len() != 0 {
+		t.Fatalf("EMF disabled but printed %q", out.String())
+	}
+
+	setGlobal(t, &emfEnabled, true)
+	emitEMF(map[string]string{"Path": "/donuts"},
+		emfMetric{Name: "Requests", Unit: "Count", Value: 1},
+		emfMetric{Name: "Latency", Unit: "Milliseconds", Value: 12.5},
+	)
+
+	var blob struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []map[string]string
+			}
+		} `json:"_aws"`
+		Path     string
+		Requests float64
+		Latency  float64
+	}
+	if err := json.Unmarshal(out.Bytes(), &blob); err != nil {
+		t.Fatalf("EMF line isn't JSON: %v\n%s", err, out.String())
+	}
+	if blob.AWS.Timestamp == 0 || len(blob.AWS.CloudWatchMetrics) != 1 {
+		t.Fatalf("bad _aws metadata: %s", out.String())
+	}
+	cw := blob.AWS.CloudWatchMetrics[0]
+	if cw.Namespace != emfNamespace || !reflect.DeepEqual(cw.Dimensions, [][]string{{"Path"}}) {
+		t.Errorf("namespace/dimensions = %s %v", cw.Namespace, cw.Dimensions)
+	}
+	wantDefs := []map[string]string{{"Name": "Requests", "Unit": "Count"}, {"Name": "Latency", "Unit": "Milliseconds"}}
+	if !reflect.DeepEqual(cw.Metrics, wantDefs) {
+		t.Errorf("metric definitions = %v", cw.Metrics)
+	}
+	if blob.Path != "/donuts" || blob.Requests != 1 || blob.Latency != 12.5 {
+		t.Errorf("values = %+v", blob)
+	}
+}
+
+func TestWithStatsEmitsPerRoute(t *testing.T) {
+	var out bytes.Buffer
+	setGlobal[io.Writer](t, &emfOut, &out)
+	setGlobal(t, &emfEnabled, true)
+
+	h := withStats(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/random/404/path", nil))
+
+	line := out.String()
+	if !strings.Contains(line, `"Path":"other"`) || !strings.Contains(line, `"Errors":1`) {
+		t.Errorf("EMF line = %s, want Path other and an error counted", line)
+	}
+}
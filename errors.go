@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
+
+// when true (HIDE_ERROR_DETAILS=true) 500s only tell the client "internal error" plus a reference id,
+// the full error with table/attribute names only goes to the logs
+var hideErrorDetails bool
+
+// ERROR_FORMAT=problem switches error bodies to RFC 7807 application/problem+json, the default is plain text
+var problemErrors bool
+
+// problem is the RFC 7807 error body.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// writeError sends an error response in whichever format ERROR_FORMAT asks for.
+func writeError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	if !problemErrors {
+		http.Error(w, detail, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:     "about:blank", // we don't have per-error docs, so the status code is the type (see RFC 7807 section 4.2)
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+}
+
+// writeDynamoError logs the full AWS error and sends the client a status that matches the cause.
+// ValidationException means the request itself was bad (wrong key type, bad expression), so it's a 400, not a server fault.
+func writeDynamoError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	errorID := newErrorID()
+	fmt.Printf("[%s] DynamoDB %s failed: %v\n", errorID, op, err) // the SDK error text already has the AWS RequestID
+
+	emitEMF(map[string]string{"Operation": op}, emfMetric{Name: "DynamoDBErrors", Unit: "Count", Value: 1})
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, r, 504, fmt.Sprintf("DynamoDB %s did not finish within %s", op, maxRequestDuration))
+		return
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ValidationException":
+			writeError(w, r, 400, "Invalid request")
+			return
+		case "AccessDeniedException":
+			// the task role (or the VPC endpoint policy) doesn't allow this call, not something the client did or can retry
+			log.Printf("WARNING: access denied for DynamoDB %s on table %s, check the task role and endpoint policy", op, tableName)
+			writeError(w, r, 403, "Access to the inventory data was denied")
+			return
+		}
+	}
+
+	if hideErrorDetails {
+		detail := "internal error (ref " + errorID
+		if id := awsRequestID(err); id != "" {
+			detail += ", AWS request ID " + id // the error text is hidden, so this is the only way to quote it in an AWS support ticket
+		}
+		writeError(w, r, 500, detail+")")
+		return
+	}
+	writeError(w, r, 500, err.Error())
+}
+
+// awsRequestID pulls the request ID AWS assigned to the failed call out of the SDK error, or "" if there isn't one.
+func awsRequestID(err error) string {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.ServiceRequestID()
+	}
+	return ""
+}
+
+// newErrorID returns a short random id so a client's error can be matched to the log line.
+func newErrorID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestWriteDynamoErrorHidesDetails(t *testing.T) {
+	err := errors.New("operation error DynamoDB: Scan, table arn:aws:dynamodb:us-west-2:123456789012:table/PDC-Inventory")
+
+	setGlobal(t, &hideErrorDetails, true)
+	rec := httptest.NewRecorder()
+	writeDynamoError(rec, httptest.NewRequest(http.MethodGet, "/all_donuts", nil), "Scan", err)
+	if rec.Code != 500 || !regexp.MustCompile(`^internal error \(ref [0-9a-f]+\)\n$`).MatchString(rec.Body.String()) {
+		t.Errorf("got %d %q, want a 500 with only a reference id", rec.Code, rec.Body.String())
+	}
+
+	setGlobal(t, &hideErrorDetails, false)
+	rec = httptest.NewRecorder()
+	writeDynamoError(rec, httptest.NewRequest(http.MethodGet, "/all_donuts", nil), "Scan", err)
+	if !strings.Contains(rec.Body.String(), "PDC-Inventory") {
+		t.Errorf("without HIDE_ERROR_DETAILS the error should be passed through, got %q", rec.Body.String())
+	}
+}
+
+func TestWriteErrorProblemJSON(t *testing.T) {
+	setGlobal(t, &problemErrors, true)
+	rec := httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodGet, "/donuts?id=9", nil), 404, "Donut not found")
+
+	if rec.Code != 404 || rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("got %d %q, want a 404 problem+json", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var p problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	want := problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "Donut not found", Instance: "/donuts"}
+	if p != want {
+		t.Errorf("problem = %+v, want %+v", p, want)
+	}
+}
+
+func TestWriteErrorPlainText(t *testing.T) {
+	setGlobal(t, &problemErrors, false)
+	rec := httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodGet, "/donuts", nil), 400, "Missing id parameter")
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") || rec.Body.String() != "Missing id parameter\n" {
+		t.Errorf("got %q %q, want plain text", rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}
+
+// sdkError wraps err the way the SDK does for a failed call, with the x-amzn-RequestId it got back.
+func sdkError(requestID string, err error) error {
+	return &smithy.OperationError{ServiceID: "DynamoDB", OperationName: "Scan", Err: &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: 500}},
+			Err:      err,
+		},
+		RequestID: requestID,
+	}}
+}
+
+func TestAWSRequestID(t *testing.T) {
+	if got := awsRequestID(sdkError("ABC123", errors.New("boom"))); got != "ABC123" {
+		t.Errorf("awsRequestID = %q, want ABC123", got)
+	}
+	if got := awsRequestID(errors.New("dial tcp: connection refused")); got != "" {
+		t.Errorf("awsRequestID without a response = %q, want empty", got)
+	}
+}
+
+func TestWriteDynamoErrorIncludesRequestID(t *testing.T) {
+	setGlobal(t, &hideErrorDetails, false)
+	rec := httptest.NewRecorder()
+	writeDynamoError(rec, httptest.NewRequest(http.MethodGet, "/all_donuts", nil), "Scan", sdkError("ABC123", errors.New("boom")))
+	if rec.Code != 500 || strings.Count(rec.Body.String(), "ABC123") != 1 {
+		t.Errorf("got %d %q, want the AWS request ID in the detail exactly once", rec.Code, rec.Body.String())
+	}
+
+	setGlobal(t, &hideErrorDetails, true)
+	rec = httptest.NewRecorder()
+	writeDynamoError(rec, httptest.NewRequest(http.MethodGet, "/all_donuts", nil), "Scan", sdkError("ABC123", errors.New("boom")))
+	if !regexp.MustCompile(`^internal error \(ref [0-9a-f]+, AWS request ID ABC123\)\n$`).MatchString(rec.Body.String()) {
+		t.Errorf("HIDE_ERROR_DETAILS body = %q, want only the ref and AWS request IDs", rec.Body.String())
+	}
+}
+
+func TestAccessDeniedIs403(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{
+		scan: func(context.Context, *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+			return nil, sdkError("ABC123", &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "User: arn:aws:sts::123456789012:assumed-role/task is not authorized"})
+		},
+	})
+	logs := captureLog(t)
+
+	rec := get(t, allDonutsHandler, "/all_donuts")
+	if rec.Code != 403 || rec.Body.String() != "Access to the inventory data was denied\n" {
+		t.Errorf("got %d %q, want a 403 without the role ARN", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(logs.String(), "check the task role and endpoint policy") {
+		t.Errorf("access denied should log a hint, got %q", logs)
+	}
+}
+
+func TestMaxRequestDurationIs504(t *testing.T) {
+	setGlobal(t, &maxRequestDuration, 20*time.Millisecond)
+	useFakeDynamo(t, &fakeDynamo{
+		getItem: func(ctx context.Context, _ *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			<-ctx.Done() // DynamoDB never answers
+			return nil, &smithy.OperationError{ServiceID: "DynamoDB", OperationName: "GetItem", Err: ctx.Err()}
+		},
+	})
+
+	rec := get(t, donutByIdHandler, "/donuts?id=1")
+	if rec.Code != 504 || !strings.Contains(rec.Body.String(), "did not finish within 20ms") {
+		t.Errorf("got %d %q, want a 504", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// HEDGE_DELAY: if a DynamoDB call hasn't come back after this long, send the same call again and take
+// whichever answers first. 0 (the default) turns hedging off. Set it around the p95 latency so only
+// the slow tail gets a second request and normal traffic isn't doubled.
+var hedgeDelay time.Duration
+
+// hedged runs call, and a second copy of it if the first is still going after hedgeDelay.
+// The first success wins and the other call's context is cancelled.
+func hedged[T any](ctx context.Context, call func(context.Context) (T, error)) (T, error) {
+	if hedgeDelay <= 0 {
+		return call(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels whichever call is still running when we return
+
+	type result struct {
+		value T
+		err   error
+	}
+	results := make(chan result, 2) // buffered so the losing goroutine never blocks
+	launch := func() {
+		go func() {
+			v, err := call(ctx)
+			results <- result{v, err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(hedgeDelay)
+	defer timer.Stop()
+
+	select {
+	case res := <-results:
+		return res.value, res.err
+	case <-timer.C:
+		stats.recordDynamoCall()
+		launch()
+	}
+
+	// two calls in flight now, a failure only counts if the other one fails too
+	res := <-results
+	if res.err == nil {
+		return res.value, nil
+	}
+	if second := <-results; second.err == nil {
+		return second.value, nil
+	}
+	return res.value, res.err
+}
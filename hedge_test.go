@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestHedgedOff(t *testing.T) {
+	setGlobal(t, &hedgeDelay, 0)
+	var calls atomic.Int32
+	v, err := hedged(context.Background(), func(context.Context) (string, error) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return "first", nil
+	})
+	if v != "first" || err != nil || calls.Load() != 1 {
+		t.Errorf("got %q, %v after %d calls, want a single call", v, err, calls.Load())
+	}
+}
+
+func TestHedgedSlowFirstCallLoses(t *testing.T) {
+	setGlobal(t, &hedgeDelay, 10*time.Millisecond)
+	var calls atomic.Int32
+	firstCancelled := make(chan bool, 1)
+	v, err := hedged(context.Background(), func(ctx context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-ctx.Done():
+				firstCancelled <- true
+				return "", ctx.Err()
+			case <-time.After(5 * time.Second):
+				return "first", nil
+			}
+		}
+		return "hedge", nil
+	})
+	if v != "hedge" || err != nil {
+		t.Fatalf("got %q, %v, want the hedge's answer", v, err)
+	}
+	select {
+	case <-firstCancelled:
+	case <-time.After(time.Second):
+		t.Error("the slow first call should be cancelled once the hedge wins")
+	}
+}
+
+func TestHedgedFastCallNoHedge(t *testing.T) {
+	setGlobal(t, &hedgeDelay, 50*time.Millisecond)
+	var calls atomic.Int32
+	v, _ := hedged(context.Background(), func(context.Context) (int, error) {
+		return int(calls.Add(1)), nil
+	})
+	time.Sleep(80 * time.Millisecond)
+	if v != 1 || calls.Load() != 1 {
+		t.Errorf("got %d after %d calls, a fast call shouldn't be hedged", v, calls.Load())
+	}
+}
+
+func TestHedgedOneFailureIsNotFatal(t *testing.T) {
+	setGlobal(t, &hedgeDelay, 10*time.Millisecond)
+	var calls atomic.Int32
+	v, err := hedged(context.Background(), func(context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			time.Sleep(30 * time.Millisecond)
+			return "", errors.New("throttled")
+		}
+		time.Sleep(40 * time.Millisecond) // finishes after the first call fails
+		return "hedge", nil
+	})
+	if v != "hedge" || err != nil {
+		t.Errorf("got %q, %v, want the hedge's success", v, err)
+	}
+
+	calls.Store(0)
+	_, err = hedged(context.Background(), func(context.Context) (string, error) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return "", errors.New("throttled")
+	})
+	if err == nil || calls.Load() != 2 {
+		t.Errorf("both calls failing = %v after %d calls, want the error", err, calls.Load())
+	}
+}
+
+// run with -race: the losing call of a hedged page must not share its ScanInput with the next page
+func TestHedgedMultiPageScan(t *testing.T) {
+	setGlobal(t, &hedgeDelay, 5*time.Millisecond)
+	pages := scanPages(
+		[]map[string]types.AttributeValue{donutItem("1", "Glazed")},
+		[]map[string]types.AttributeValue{donutItem("2", "Maple")},
+		[]map[string]types.AttributeValue{donutItem("3", "Jelly")},
+	)
+	var calls atomic.Int32
+	useFakeDynamo(t, &fakeDynamo{scan: func(ctx context.Context, in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		if calls.Add(1)%2 == 1 {
+			// the first call of each page is slow, so the hedge wins and this one finishes afterwards
+			time.Sleep(20 * time.Millisecond)
+		}
+		return pages(ctx, in)
+	}})
+
+	rec := get(t, allDonutsHandler, "/all_donuts")
+	if got := strings.Count(rec.Body.String(), "itemId"); rec.Code != 200 || got != 3 {
+		t.Errorf("got %d with %d donuts, want all three pages", rec.Code, got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ipLimiter caps how many requests a single client IP can have in flight at once.
+// An IP's entry is removed as soon as its last request finishes, so idle clients don't use any memory.
+type ipLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight map[string]int
+}
+
+func newIPLimiter(limit int) *ipLimiter {
+	return &ipLimiter{limit: limit, inFlight: make(map[string]int)}
+}
+
+func (l *ipLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[ip] >= l.limit {
+		return false
+	}
+	l.inFlight[ip]++
+	return true
+}
+
+func (l *ipLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight[ip]--
+	if l.inFlight[ip] <= 0 {
+		delete(l.inFlight, ip)
+	}
+}
+
+// withIPConcurrency rejects a request with 429 when its client is already at the in-flight limit.
+func withIPConcurrency(l *ipLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if !l.acquire(ip) {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, r, http.StatusTooManyRequests, "Too many concurrent requests")
+			return
+		}
+		defer l.release(ip)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP prefers X-Forwarded-For since requests come in through API Gateway and the NLB,
+// so RemoteAddr is usually a load balancer address rather than the real client. It takes the last entry,
+// the one API Gateway appends itself; anything before it came from the client and can be made up.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		last := fwd[strings.LastIndex(fwd, ",")+1:]
+		return strings.TrimSpace(last)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
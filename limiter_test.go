@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPLimiterAcquireRelease(t *testing.T) {
+	l := newIPLimiter(2)
+	if !l.acquire("1.2.3.4") || !l.acquire("1.2.3.4") {
+		t.Fatal("first two requests should get in")
+	}
+	if l.acquire("1.2.3.4") {
+		t.Error("third request should be over the limit")
+	}
+	if !l.acquire("5.6.7.8") {
+		t.Error("another IP has its own limit")
+	}
+
+	l.release("1.2.3.4")
+	if !l.acquire("1.2.3.4") {
+		t.Error("a slot should free up after release")
+	}
+	l.release("1.2.3.4")
+	l.release("1.2.3.4")
+	l.release("5.6.7.8")
+	if len(l.inFlight) != 0 {
+		t.Errorf("idle IPs should be dropped, got %v", l.inFlight)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		fwd, remote, want string
+	}{
+		{"", "10.0.0.1:5555", "10.0.0.1"},
+		{"203.0.113.7", "10.0.0.1:5555", "203.0.113.7"},
+		// the client can send its own X-Forwarded-For, only the entry API Gateway appends is trusted
+		{"1.1.1.1, 203.0.113.7", "10.0.0.1:5555", "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/all_donuts", nil)
+		r.RemoteAddr = tt.remote
+		if tt.fwd != "" {
+			r.Header.Set("X-Forwarded-For", tt.fwd)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%q, %q) = %q, want %q", tt.fwd, tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestIPConcurrency429(t *testing.T) {
+	l := newIPLimiter(1)
+	l.acquire("203.0.113.7") // that client already has a request in flight
+
+	reached := false
+	h := withCORS(withIPConcurrency(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/all_donuts", nil)
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 203.0.113.7")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusTooManyRequests || reached {
+		t.Fatalf("got %d (handler reached: %v), want 429", w.Code, reached)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Error("429 should carry CORS headers so browsers can read it")
+	}
+}
@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 type Donut struct {
@@ -19,30 +29,258 @@ type Donut struct {
 	Name   string `json:"name"   dynamodbav:"Name"`
 }
 
-var db *dynamodb.Client
-const tableName = "PDC-Inventory"
+// dynamoAPI is the part of the DynamoDB client the handlers use, so tests can swap in a fake.
+type dynamoAPI interface {
+	Scan(ctx context.Context, in *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	GetItem(ctx context.Context, in *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	DescribeLimits(ctx context.Context, in *dynamodb.DescribeLimitsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeLimitsOutput, error)
+}
+
+var db dynamoAPI
+
+// openapi.json describes the endpoints below, update it when a handler's params or responses change
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+const defaultTableName = "PDC-Inventory"
+
+var tableName = defaultTableName
+
+// Cache-Control max-age (seconds) sent on donut responses, 0 means no header. ?maxAge= can override it per request up to maxCacheMaxAge.
+var cacheMaxAge int
+
+const maxCacheMaxAge = 86400
+
+// requests slower than this (SLOW_QUERY_THRESHOLD, e.g. 500ms) are logged as a warning, 0 disables it
+var slowQueryThreshold time.Duration
+
+// hard ceiling (MAX_REQUEST_DURATION) on the DynamoDB work for one request, including SDK retries and hedged calls. 0 means no limit
+var maxRequestDuration time.Duration
+
+// most items /all_donuts will read across scan pages (MAX_SCAN_ITEMS), so a huge table can't exhaust memory. 0 means no limit
+var maxScanItems = 10000
+
+// a scan consuming more read capacity units than SCAN_CAPACITY_ALARM logs a warning, and with
+// SCAN_CAPACITY_ABORT=true it's stopped with a 503 so one request can't keep draining a shared table
+var scanCapacityAlarm float64
+var scanCapacityAbort bool
+
+// when true (EMPTY_AS_204=true) an empty scan is a 204 with no body instead of a 200 with an empty list
+var emptyAs204 bool
 
 func main() {
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion("us-west-2"),
-	)
+	cfg, err := loadAWSConfig(context.TODO())
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
 	db = dynamodb.NewFromConfig(cfg)
+	tableName = resolveTableName()
+	hideErrorDetails = os.Getenv("HIDE_ERROR_DETAILS") == "true"
+	emptyAs204 = os.Getenv("EMPTY_AS_204") == "true"
+	defaultSort, err = parseDonutSort(os.Getenv("DEFAULT_SORT"))
+	if err != nil {
+		log.Fatalf("invalid DEFAULT_SORT: %v", err)
+	}
+	switch format := os.Getenv("METRICS_FORMAT"); format {
+	case "":
+	case "emf":
+		emfEnabled = true
+	default:
+		log.Fatalf("invalid METRICS_FORMAT %q, expected emf", format)
+	}
+	switch format := os.Getenv("ERROR_FORMAT"); format {
+	case "", "text":
+	case "problem":
+		problemErrors = true
+	default:
+		log.Fatalf("invalid ERROR_FORMAT %q, expected text or problem", format)
+	}
+	slowQueryThreshold = envDuration("SLOW_QUERY_THRESHOLD", 0)
+	hedgeDelay = envDuration("HEDGE_DELAY", 0)
+	maxRequestDuration = envDuration("MAX_REQUEST_DURATION", 5*time.Second)
+	if v := os.Getenv("SCAN_CAPACITY_ALARM"); v != "" {
+		scanCapacityAlarm, err = strconv.ParseFloat(v, 64)
+		if err != nil || scanCapacityAlarm < 0 {
+			log.Fatalf("invalid SCAN_CAPACITY_ALARM %q, expected a number of read capacity units", v)
+		}
+	}
+	scanCapacityAbort = os.Getenv("SCAN_CAPACITY_ABORT") == "true"
+	if v := os.Getenv("MAX_SCAN_ITEMS"); v != "" {
+		maxScanItems, err = strconv.Atoi(v)
+		if err != nil || maxScanItems < 0 {
+			log.Fatalf("invalid MAX_SCAN_ITEMS %q, expected a number of items (0 for no limit)", v)
+		}
+	}
+	if v := os.Getenv("CACHE_CONTROL_MAX_AGE"); v != "" {
+		cacheMaxAge, err = strconv.Atoi(v)
+		if err != nil || cacheMaxAge < 0 || cacheMaxAge > maxCacheMaxAge {
+			log.Fatalf("invalid CACHE_CONTROL_MAX_AGE %q, expected seconds between 0 and %d", v, maxCacheMaxAge)
+		}
+	}
+	fmt.Printf("Using table %s\n", tableName)
+	checkTable(context.TODO(), cfg.Region)
+
+	if v := os.Getenv("WARMUP_CONNECTIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid WARMUP_CONNECTIONS %q, expected a number of connections", v)
+		}
+		if err := warmConnections(context.TODO(), n); err != nil {
+			log.Printf("WARNING: connection warmup failed, first requests may be slower: %v", err)
+		}
+	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", withCORS(healthHandler))
-	mux.HandleFunc("/all_donuts", withCORS(allDonutsHandler))
-	mux.HandleFunc("/donuts", withCORS(donutByIdHandler))
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/all_donuts", allDonutsHandler)
+	mux.HandleFunc("/donuts", donutByIdHandler)
+	mux.HandleFunc("/stats", statsHandler)
+	mux.HandleFunc("/openapi.json", openAPIHandler)
+
+	slashMode := os.Getenv("TRAILING_SLASH")
+	if slashMode == "" {
+		slashMode = "strip"
+	}
+	if slashMode != "strip" && slashMode != "redirect" && slashMode != "strict" {
+		log.Fatalf("invalid TRAILING_SLASH %q, expected strip, redirect or strict", slashMode)
+	}
 
+	var handler http.Handler = withTrailingSlash(slashMode, mux)
+	debugFilter, err := parseDebugLogFilter(os.Getenv("DEBUG_LOG_FILTER"), os.Getenv("DEBUG_LOG_REDACT"))
+	if err != nil {
+		log.Fatalf("invalid DEBUG_LOG_FILTER: %v", err)
+	}
+	if debugFilter != nil {
+		handler = withDebugLog(debugFilter, handler)
+	}
+	if v := os.Getenv("PER_IP_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid PER_IP_CONCURRENCY %q, expected a positive number", v)
+		}
+		handler = withIPConcurrency(newIPLimiter(n), handler)
+	}
+
+	srv := newServer(handler)
 	fmt.Println("Server active at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	if err := runServer(srv, envDuration("SHUTDOWN_TIMEOUT", 10*time.Second)); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func withCORS(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// loadAWSConfig builds the SDK config. When ASSUME_ROLE_ARN is set the client reads the table with
+// credentials from STS AssumeRole (for a table in another account), otherwise the default chain is used.
+func loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(resolveRegion(ctx)),
+	)
+	if err != nil {
+		return cfg, err
+	}
+
+	roleArn := os.Getenv("ASSUME_ROLE_ARN")
+	if roleArn == "" {
+		return cfg, nil
+	}
+
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn, func(o *stscreds.AssumeRoleOptions) {
+		if externalID := os.Getenv("ASSUME_ROLE_EXTERNAL_ID"); externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+		if sessionName := os.Getenv("ASSUME_ROLE_SESSION_NAME"); sessionName != "" {
+			o.RoleSessionName = sessionName
+		}
+	})
+	cfg.Credentials = aws.NewCredentialsCache(provider) // cache so we don't call STS on every request
+	fmt.Printf("Using AssumeRole credentials for %s\n", roleArn)
+	return cfg, nil
+}
+
+// envDuration reads a duration like 500ms or 30s from the environment, exiting on a bad value.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("invalid %s %q, expected a duration like 500ms or 30s", name, v)
+	}
+	return d
+}
+
+// resolveTableName picks the table from the environment. An explicit TABLE_NAME always wins,
+// otherwise TABLE_BASE_NAME and ENVIRONMENT compose into {base}-{env} (e.g. items-dev).
+func resolveTableName() string {
+	if name := os.Getenv("TABLE_NAME"); name != "" {
+		return name
+	}
+	base := os.Getenv("TABLE_BASE_NAME")
+	if base == "" {
+		return defaultTableName
+	}
+	if env := os.Getenv("ENVIRONMENT"); env != "" {
+		return base + "-" + env
+	}
+	return base
+}
+
+// checkTable describes the table once at startup. A ResourceNotFoundException here is nearly always the table
+// living in a different region than the one configured, which is easy to misdiagnose from request errors later.
+func checkTable(ctx context.Context, region string) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := db.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err == nil {
+		return
+	}
+
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		log.Printf("WARNING: table %s not found in region %s. If the table exists, check that AWS_REGION matches the region it was created in.", tableName, region)
+		return
+	}
+	log.Printf("WARNING: could not describe table %s in region %s: %v", tableName, region, err)
+}
+
+// the SDK's default HTTP transport keeps at most this many idle connections per host, any more warmed up get closed
+const maxWarmupConnections = 10
+
+// warmConnections makes n concurrent DescribeLimits calls (cheap, no table reads) so the SDK's HTTP pool
+// already has TLS connections to DynamoDB open before the first real request shows up.
+func warmConnections(ctx context.Context, n int) error {
+	if n > maxWarmupConnections {
+		log.Printf("WARNING: WARMUP_CONNECTIONS of %d is over the %d idle connections the HTTP pool keeps, warming %d", n, maxWarmupConnections, maxWarmupConnections)
+		n = maxWarmupConnections
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = db.DescribeLimits(ctx, &dynamodb.DescribeLimitsInput{})
+		}(i)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	fmt.Printf("Warmed %d DynamoDB connections in %s\n", n, time.Since(start))
+	return nil
+}
+
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -50,7 +288,63 @@ func withCORS(next http.HandlerFunc) http.HandlerFunc {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		next(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withTrailingSlash handles paths like /donuts/ before they reach the mux, which only has /donuts registered.
+// strip rewrites the path in place, redirect sends a 308 to the slashless path, strict leaves it alone (so it 404s).
+func withTrailingSlash(mode string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mode == "strict" || r.URL.Path == "/" || !strings.HasSuffix(r.URL.Path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// path.Clean also collapses repeated slashes, so //evil.com/ becomes /evil.com and can never
+		// turn into a protocol-relative Location header
+		cleaned := path.Clean(r.URL.Path)
+		if mode == "redirect" {
+			target := cleaned
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusPermanentRedirect) // 308 so the method and body are kept
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = cleaned
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// requestContext is the context DynamoDB calls for r run under. It ends when the client goes away or maxRequestDuration passes.
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if maxRequestDuration <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), maxRequestDuration)
+}
+
+// cacheControlMaxAge returns the max-age to send: the ?maxAge= override if given, otherwise CACHE_CONTROL_MAX_AGE.
+func cacheControlMaxAge(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("maxAge")
+	if v == "" {
+		return cacheMaxAge, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > maxCacheMaxAge {
+		return 0, fmt.Errorf("maxAge must be a number of seconds between 0 and %d", maxCacheMaxAge)
+	}
+	return n, nil
+}
+
+// setCacheControl is only called once the response is known to be good, so errors never get cached.
+func setCacheControl(w http.ResponseWriter, maxAge int) {
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
 	}
 }
 
@@ -59,43 +353,217 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
 func allDonutsHandler(w http.ResponseWriter, r *http.Request) {
-	out, err := db.Scan(context.TODO(), &dynamodb.ScanInput{TableName: aws.String(tableName)}) // & is creating a pointer to the ScanInput struct. this way we dont pass a large object to the function
+	maxAge, err := cacheControlMaxAge(r)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeError(w, r, 400, err.Error())
 		return
 	}
 
-	var donuts []Donut
-	attributevalue.UnmarshalListOfMaps(out.Items, &donuts) // passing the pointer with & also allows the function to modify the original donuts, instead of getting a temporary copy of it.
-	
+	if r.URL.Query().Get("explain") == "true" {
+		writePlan(w, queryPlan{
+			Operation:     "Scan",
+			Table:         tableName,
+			EstimatedCost: "reads every item in the table, about 1 RCU per 8KB scanned (eventually consistent)",
+		})
+		return
+	}
+
+	start := time.Now()
+	itemCount := 0
+	defer func() { logQueryDuration("scan", itemCount, time.Since(start)) }()
+	timing := newServerTiming(r)
+
+	ctx, cancel := requestContext(r)
+	defer cancel()
+
+	// a single Scan call returns at most 1MB, so keep following LastEvaluatedKey until the table is done.
+	// every page uses ctx, so MAX_REQUEST_DURATION still stops a long scan
+	input := &dynamodb.ScanInput{ // & is creating a pointer to the ScanInput struct. this way we dont pass a large object to the function
+		TableName:              aws.String(tableName),
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	}
+	var items []map[string]types.AttributeValue
+	consumedRCU := 0.0
+	alarmed := false   // the capacity warning is logged once per request, not once per page
+	truncated := false // MAX_SCAN_ITEMS cut the scan short
+	for {
+		stats.recordDynamoCall()
+		// each page gets its own copy of the input, a losing hedged call may still be reading it
+		// after ExclusiveStartKey is moved on below
+		page := *input
+		out, err := hedged(ctx, func(ctx context.Context) (*dynamodb.ScanOutput, error) {
+			return db.Scan(ctx, &page)
+		})
+		if err != nil {
+			writeDynamoError(w, r, "Scan", err)
+			return
+		}
+		items = append(items, out.Items...)
+
+		if out.ConsumedCapacity != nil && out.ConsumedCapacity.CapacityUnits != nil {
+			consumedRCU += *out.ConsumedCapacity.CapacityUnits
+		}
+		if scanCapacityAlarm > 0 && consumedRCU > scanCapacityAlarm && !alarmed {
+			alarmed = true
+			log.Printf("WARNING: scan of %s consumed %.1f RCU, over SCAN_CAPACITY_ALARM of %.1f", tableName, consumedRCU, scanCapacityAlarm)
+			if scanCapacityAbort {
+				writeError(w, r, 503, "Scan exceeded its read capacity budget")
+				return
+			}
+		}
+
+		if maxScanItems > 0 && len(items) >= maxScanItems {
+			if len(out.LastEvaluatedKey) > 0 || len(items) > maxScanItems {
+				log.Printf("WARNING: scan of %s stopped at MAX_SCAN_ITEMS (%d), results are truncated", tableName, maxScanItems)
+				truncated = true
+			}
+			items = items[:maxScanItems]
+			break
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	timing.since("dynamodb", start)
+
+	setCacheControl(w, maxAge)
+	if truncated {
+		w.Header().Set("X-Truncated", "true") // so clients can tell a partial list from the whole table
+	}
+
+	transformStart := time.Now()
+	donuts := []Donut{}                                // start non-nil so an empty table encodes as [] and not null
+	attributevalue.UnmarshalListOfMaps(items, &donuts) // passing the pointer with & also allows the function to modify the original donuts, instead of getting a temporary copy of it.
+
+	defaultSort.apply(donuts)
+	itemCount = len(donuts)
 	fmt.Printf("Scan successful. Found %d items.\n", len(donuts))
-	json.NewEncoder(w).Encode(donuts) // w writes directly to the HTTP response body, json.NewEncoder encodes the donuts slice as JSON and sends it in the response
+
+	timing.since("transform", transformStart)
+
+	if len(donuts) == 0 && emptyAs204 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// ?keyed=true returns {"items": {"<itemId>": {...}}} for clients that look donuts up by id
+	if r.URL.Query().Get("keyed") == "true" {
+		keyed := make(map[string]Donut, len(donuts))
+		for _, d := range donuts {
+			if _, dup := keyed[d.ItemId]; dup {
+				fmt.Printf("Duplicate ItemId '%s' in scan results, keeping the last one\n", d.ItemId)
+			}
+			keyed[d.ItemId] = d
+		}
+		if err := writeJSON(w, map[string]map[string]Donut{"items": keyed}, timing); err != nil {
+			fmt.Printf("Failed to write response: %v\n", err)
+		}
+		return
+	}
+
+	err = writeJSON(w, donuts, timing) // w writes directly to the HTTP response body, writeJSON encodes the donuts slice as JSON and sends it in the response
 	// there is no return statement because we are modifying the HTTP response directly through the http.ResponseWriter interface.
+	if err != nil {
+		fmt.Printf("Failed to write response: %v\n", err) // usually the client went away or WRITE_TIMEOUT passed
+	}
 }
 
 func donutByIdHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 	fmt.Printf("Searching for ID: '%s'\n", id) // DEBUG 1
 	if id == "" {
-		http.Error(w, "Missing id parameter", 400)
+		writeError(w, r, 400, "Missing id parameter")
+		return
+	}
+	maxAge, err := cacheControlMaxAge(r)
+	if err != nil {
+		writeError(w, r, 400, err.Error())
 		return
 	}
 
-	out, err := db.GetItem(context.TODO(), &dynamodb.GetItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"ItemID": &types.AttributeValueMemberS{Value: id},
-		},
+	if r.URL.Query().Get("explain") == "true" {
+		writePlan(w, queryPlan{
+			Operation:     "GetItem",
+			Table:         tableName,
+			KeyCount:      1,
+			Key:           map[string]string{"ItemID": id},
+			EstimatedCost: "0.5 RCU per 4KB of the item (eventually consistent read)",
+		})
+		return
+	}
+
+	start := time.Now()
+	itemCount := 0
+	defer func() { logQueryDuration("get", itemCount, time.Since(start)) }()
+	timing := newServerTiming(r)
+
+	stats.recordDynamoCall()
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	out, err := hedged(ctx, func(ctx context.Context) (*dynamodb.GetItemOutput, error) {
+		return db.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"ItemID": &types.AttributeValueMemberS{Value: id},
+			},
+		})
 	})
 
-	if err != nil || out.Item == nil {
-		fmt.Printf(err.Error())
-		http.Error(w, "Donut not found", 404)
+	if err != nil {
+		writeDynamoError(w, r, "GetItem", err)
+		return
+	}
+	if out.Item == nil {
+		writeError(w, r, 404, "Donut not found")
 		return
 	}
+	timing.since("dynamodb", start)
 
+	setCacheControl(w, maxAge)
+
+	itemCount = 1
+	transformStart := time.Now()
 	var d Donut
 	attributevalue.UnmarshalMap(out.Item, &d)
-	json.NewEncoder(w).Encode(d)
-}
\ No newline at end of file
+	timing.since("transform", transformStart)
+	if err := writeJSON(w, d, timing); err != nil {
+		fmt.Printf("Failed to write response: %v\n", err)
+	}
+}
+
+// queryPlan is what ?explain=true returns instead of running the query, so clients can see how a request hits DynamoDB.
+type queryPlan struct {
+	Operation     string            `json:"operation"`
+	Table         string            `json:"table,omitempty"`
+	KeyCount      int               `json:"keyCount"`
+	Key           map[string]string `json:"key,omitempty"`
+	EstimatedCost string            `json:"estimatedCost"`
+}
+
+// writePlan leaves out the table and key attribute names under HIDE_ERROR_DETAILS, same as it hides them in errors.
+func writePlan(w http.ResponseWriter, plan queryPlan) {
+	if hideErrorDetails {
+		plan.Table = ""
+		plan.Key = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// logQueryDuration logs how long a donut lookup took, as a warning when it's over SLOW_QUERY_THRESHOLD.
+func logQueryDuration(op string, items int, elapsed time.Duration) {
+	emitEMF(map[string]string{"Operation": op}, emfMetric{Name: "ItemCount", Unit: "Count", Value: float64(items)})
+
+	if slowQueryThreshold > 0 && elapsed > slowQueryThreshold {
+		log.Printf("WARNING: slow %s request took %s (threshold %s), %d items", op, elapsed, slowQueryThreshold, items)
+		return
+	}
+	fmt.Printf("%s request took %s, %d items\n", op, elapsed, items)
+}
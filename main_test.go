@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeDynamo implements dynamoAPI with whatever funcs a test sets, unset calls return empty output.
+type fakeDynamo struct {
+	scan           func(ctx context.Context, in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error)
+	getItem        func(ctx context.Context, in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	describeTable  func(ctx context.Context, in *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error)
+	describeLimits func(ctx context.Context, in *dynamodb.DescribeLimitsInput) (*dynamodb.DescribeLimitsOutput, error)
+}
+
+func (f *fakeDynamo) Scan(ctx context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if f.scan == nil {
+		return &dynamodb.ScanOutput{}, nil
+	}
+	return f.scan(ctx, in)
+}
+
+func (f *fakeDynamo) GetItem(ctx context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if f.getItem == nil {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return f.getItem(ctx, in)
+}
+
+func (f *fakeDynamo) DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	if f.describeTable == nil {
+		return &dynamodb.DescribeTableOutput{}, nil
+	}
+	return f.describeTable(ctx, in)
+}
+
+func (f *fakeDynamo) DescribeLimits(ctx context.Context, in *dynamodb.DescribeLimitsInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeLimitsOutput, error) {
+	if f.describeLimits == nil {
+		return &dynamodb.DescribeLimitsOutput{}, nil
+	}
+	return f.describeLimits(ctx, in)
+}
+
+// useFakeDynamo points db at f for the rest of the test.
+func useFakeDynamo(t *testing.T, f *fakeDynamo) {
+	t.Helper()
+	prev := db
+	db = f
+	t.Cleanup(func() { db = prev })
+}
+
+// setGlobal changes a package config var for one test and puts it back afterwards.
+func setGlobal[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	prev := *v
+	*v = value
+	t.Cleanup(func() { *v = prev })
+}
+
+func donutItem(id, name string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ItemId": &types.AttributeValueMemberS{Value: id},
+		"Name":   &types.AttributeValueMemberS{Value: name},
+	}
+}
+
+// scanPages returns a scan func that hands out the given pages in order, linked with LastEvaluatedKey.
+func scanPages(pages ...[]map[string]types.AttributeValue) func(context.Context, *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return func(_ context.Context, in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		page := 0
+		if in.ExclusiveStartKey != nil {
+			page, _ = strconv.Atoi(in.ExclusiveStartKey["page"].(*types.AttributeValueMemberN).Value)
+		}
+		out := &dynamodb.ScanOutput{Items: pages[page]}
+		if page+1 < len(pages) {
+			out.LastEvaluatedKey = map[string]types.AttributeValue{
+				"page": &types.AttributeValueMemberN{Value: strconv.Itoa(page + 1)},
+			}
+		}
+		return out, nil
+	}
+}
+
+// withCapacity wraps a scan func so every page reports rcu consumed read capacity units.
+func withCapacity(rcu float64, scan func(context.Context, *dynamodb.ScanInput) (*dynamodb.ScanOutput, error)) func(context.Context, *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return func(ctx context.Context, in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		out, err := scan(ctx, in)
+		if out != nil {
+			out.ConsumedCapacity = &types.ConsumedCapacity{CapacityUnits: aws.Float64(rcu)}
+		}
+		return out, err
+	}
+}
+
+// captureLog sends the standard logger to a buffer for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func get(t *testing.T, handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestDonutByIdValidationExceptionIs400(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{
+		getItem: func(context.Context, *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "ValidationException", Message: "The provided key element does not match the schema"}
+		},
+	})
+
+	rec := get(t, donutByIdHandler, "/donuts?id=1")
+	if rec.Code != 400 {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if body := rec.Body.String(); body != "Invalid request\n" {
+		t.Errorf("body = %q, want the sanitized message", body)
+	}
+}
+
+func TestAllDonutsOtherErrorsAre500(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{
+		scan: func(context.Context, *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "InternalServerError", Message: "boom"}
+		},
+	})
+
+	if rec := get(t, allDonutsHandler, "/all_donuts"); rec.Code != 500 {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+}
+
+func TestAllDonutsEmptyTableEncodesEmptyArray(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{})
+
+	rec := get(t, allDonutsHandler, "/all_donuts")
+	if rec.Code != 200 {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if body := rec.Body.String(); body != "[]\n" {
+		t.Errorf("body = %q, want []", body)
+	}
+}
+
+// slashTestMux records the path each request reached the mux with.
+func slashTestMux(reached *string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/donuts", func(w http.ResponseWriter, r *http.Request) {
+		*reached = r.URL.Path + "?" + r.URL.RawQuery
+	})
+	return mux
+}
+
+func TestTrailingSlashStrip(t *testing.T) {
+	var reached string
+	h := withCORS(withTrailingSlash("strip", slashTestMux(&reached)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/donuts/?id=1", nil))
+	if rec.Code != 200 || reached != "/donuts?id=1" {
+		t.Fatalf("status = %d, reached %q, want 200 at /donuts?id=1", rec.Code, reached)
+	}
+}
+
+func TestTrailingSlashRedirect(t *testing.T) {
+	var reached string
+	h := withCORS(withTrailingSlash("redirect", slashTestMux(&reached)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/donuts/?id=1", nil))
+	if rec.Code != http.StatusPermanentRedirect {
+		t.Fatalf("status = %d, want 308", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/donuts?id=1" {
+		t.Errorf("Location = %q, want /donuts?id=1", loc)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("redirect is missing CORS headers")
+	}
+	if reached != "" {
+		t.Errorf("redirect should not reach the handler, got %q", reached)
+	}
+}
+
+func TestTrailingSlashRedirectIsNotOpen(t *testing.T) {
+	var reached string
+	h := withTrailingSlash("redirect", slashTestMux(&reached))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "//evil.com/", nil))
+	if loc := rec.Header().Get("Location"); loc != "/evil.com" {
+		t.Errorf("Location = %q, want the local path /evil.com", loc)
+	}
+}
+
+func TestTrailingSlashStrict(t *testing.T) {
+	var reached string
+	h := withTrailingSlash("strict", slashTestMux(&reached))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/donuts/", nil))
+	if rec.Code != 404 || reached != "" {
+		t.Fatalf("status = %d, reached %q, want a 404 without reaching /donuts", rec.Code, reached)
+	}
+}
+
+func TestCORSPreflightOnTrailingSlash(t *testing.T) {
+	var reached string
+	h := withCORS(withTrailingSlash("redirect", slashTestMux(&reached)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/donuts/", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("preflight status = %d, headers %v, want 204 with CORS headers", rec.Code, rec.Header())
+	}
+}
+
+func TestAllDonutsFollowsEveryPage(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{scan: scanPages(
+		[]map[string]types.AttributeValue{donutItem("1", "Glazed")},
+		[]map[string]types.AttributeValue{donutItem("2", "Maple")},
+		[]map[string]types.AttributeValue{donutItem("3", "Jelly")},
+	)})
+
+	rec := get(t, allDonutsHandler, "/all_donuts")
+	if rec.Code != 200 || rec.Header().Get("X-Truncated") != "" {
+		t.Fatalf("status = %d, X-Truncated = %q, want a full 200", rec.Code, rec.Header().Get("X-Truncated"))
+	}
+	want := `[{"itemId":"1","name":"Glazed"},{"itemId":"2","name":"Maple"},{"itemId":"3","name":"Jelly"}]` + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("body = %s, want all three pages", rec.Body.String())
+	}
+}
+
+func TestAllDonutsMaxScanItemsTruncates(t *testing.T) {
+	setGlobal(t, &maxScanItems, 2)
+	useFakeDynamo(t, &fakeDynamo{scan: scanPages(
+		[]map[string]types.AttributeValue{donutItem("1", "Glazed")},
+		[]map[string]types.AttributeValue{donutItem("2", "Maple"), donutItem("3", "Jelly")},
+		[]map[string]types.AttributeValue{donutItem("4", "Cruller")},
+	)})
+	captureLog(t)
+
+	rec := get(t, allDonutsHandler, "/all_donuts")
+	if rec.Header().Get("X-Truncated") != "true" {
+		t.Errorf("X-Truncated = %q, want true", rec.Header().Get("X-Truncated"))
+	}
+	if got := strings.Count(rec.Body.String(), "itemId"); got != 2 {
+		t.Errorf("got %d donuts, want MAX_SCAN_ITEMS of 2", got)
+	}
+}
+
+func TestAllDonutsCapacityAlarmLoggedOnce(t *testing.T) {
+	setGlobal(t, &scanCapacityAlarm, 1)
+	useFakeDynamo(t, &fakeDynamo{scan: withCapacity(5, scanPages(
+		[]map[string]types.AttributeValue{donutItem("1", "Glazed")},
+		[]map[string]types.AttributeValue{donutItem("2", "Maple")},
+		[]map[string]types.AttributeValue{donutItem("3", "Jelly")},
+	))})
+	logs := captureLog(t)
+
+	if rec := get(t, allDonutsHandler, "/all_donuts"); rec.Code != 200 {
+		t.Fatalf("status = %d, want 200 without SCAN_CAPACITY_ABORT", rec.Code)
+	}
+	if n := strings.Count(logs.String(), "SCAN_CAPACITY_ALARM"); n != 1 {
+		t.Errorf("capacity warning logged %d times, want once:\n%s", n, logs)
+	}
+}
+
+func TestExplainPlan(t *testing.T) {
+	setGlobal(t, &tableName, "PDC-Inventory")
+	useFakeDynamo(t, &fakeDynamo{
+		scan: func(context.Context, *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+			t.Error("explain should not call DynamoDB")
+			return nil, nil
+		},
+		getItem: func(context.Context, *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			t.Error("explain should not call DynamoDB")
+			return nil, nil
+		},
+	})
+
+	tests := []struct {
+		handler http.HandlerFunc
+		target  string
+		hide    bool
+		want    string
+	}{
+		{allDonutsHandler, "/all_donuts?explain=true", false, `"table":"PDC-Inventory"`},
+		{donutByIdHandler, "/donuts?id=1&explain=true", false, `"key":{"ItemID":"1"}`},
+		{allDonutsHandler, "/all_donuts?explain=true", true, `"operation":"Scan"`},
+		{donutByIdHandler, "/donuts?id=1&explain=true", true, `"operation":"GetItem"`},
+	}
+	for _, tt := range tests {
+		setGlobal(t, &hideErrorDetails, tt.hide)
+		body := get(t, tt.handler, tt.target).Body.String()
+		if !strings.Contains(body, tt.want) {
+			t.Errorf("%s (hide=%v) = %s, want it to contain %s", tt.target, tt.hide, body, tt.want)
+		}
+		if tt.hide && (strings.Contains(body, "PDC-Inventory") || strings.Contains(body, "ItemID")) {
+			t.Errorf("%s with HIDE_ERROR_DETAILS leaks table or key names: %s", tt.target, body)
+		}
+	}
+}
+
+func TestWarmConnections(t *testing.T) {
+	var calls atomic.Int32
+	useFakeDynamo(t, &fakeDynamo{
+		describeLimits: func(context.Context, *dynamodb.DescribeLimitsInput) (*dynamodb.DescribeLimitsOutput, error) {
+			calls.Add(1)
+			return &dynamodb.DescribeLimitsOutput{}, nil
+		},
+	})
+	logs := captureLog(t)
+
+	if err := warmConnections(context.Background(), 3); err != nil || calls.Load() != 3 {
+		t.Fatalf("warmConnections(3) = %v after %d calls, want 3 calls", err, calls.Load())
+	}
+
+	calls.Store(0)
+	if err := warmConnections(context.Background(), 50); err != nil || calls.Load() != maxWarmupConnections {
+		t.Errorf("warmConnections(50) = %v after %d calls, want it clamped to %d", err, calls.Load(), maxWarmupConnections)
+	}
+	if !strings.Contains(logs.String(), "WARMUP_CONNECTIONS of 50") {
+		t.Errorf("clamping should be logged, got %q", logs)
+	}
+}
+
+func TestWarmConnectionsReportsErrors(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{
+		describeLimits: func(context.Context, *dynamodb.DescribeLimitsInput) (*dynamodb.DescribeLimitsOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "AccessDeniedException"}
+		},
+	})
+	if err := warmConnections(context.Background(), 2); err == nil {
+		t.Error("a failed DescribeLimits should be returned")
+	}
+}
+
+func TestAllDonutsKeyed(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{scan: scanPages(
+		[]map[string]types.AttributeValue{donutItem("1", "Glazed"), donutItem("2", "Maple")},
+	)})
+
+	rec := get(t, allDonutsHandler, "/all_donuts?keyed=true")
+	want := `{"items":{"1":{"itemId":"1","name":"Glazed"},"2":{"itemId":"2","name":"Maple"}}}` + "\n"
+	if rec.Code != 200 || rec.Body.String() != want {
+		t.Errorf("got %d %s, want %s", rec.Code, rec.Body.String(), want)
+	}
+}
+
+// awsTestEnv keeps config loading off the real machine: fixed region and keys, no shared config, no IMDS.
+func awsTestEnv(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-west-2")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func TestLoadAWSConfigAssumeRole(t *testing.T) {
+	awsTestEnv(t)
+	var form url.Values
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>
+<Credentials><AccessKeyId>ASIAROLE</AccessKeyId><SecretAccessKey>s</SecretAccessKey><SessionToken>tok</SessionToken>
+<Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+	}))
+	defer sts.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+	t.Setenv("ASSUME_ROLE_ARN", "arn:aws:iam::123456789012:role/donut-reader")
+	t.Setenv("ASSUME_ROLE_EXTERNAL_ID", "ext-1")
+	t.Setenv("ASSUME_ROLE_SESSION_NAME", "pdc-app")
+
+	cfg, err := loadAWSConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAROLE" {
+		t.Errorf("access key = %q, want the assumed role's", creds.AccessKeyID)
+	}
+	if form.Get("RoleArn") != "arn:aws:iam::123456789012:role/donut-reader" || form.Get("ExternalId") != "ext-1" || form.Get("RoleSessionName") != "pdc-app" {
+		t.Errorf("AssumeRole request = %v", form)
+	}
+}
+
+func TestLoadAWSConfigWithoutAssumeRole(t *testing.T) {
+	awsTestEnv(t)
+	t.Setenv("ASSUME_ROLE_ARN", "")
+
+	cfg, err := loadAWSConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if err != nil || creds.AccessKeyID != "AKIDTEST" {
+		t.Errorf("credentials = %v, %v, want the environment's", creds.AccessKeyID, err)
+	}
+}
+
+func TestResolveTableName(t *testing.T) {
+	tests := []struct {
+		tableName, base, env, want string
+	}{
+		{"", "", "", defaultTableName},
+		{"Donuts", "PDC-Inventory", "prod", "Donuts"},
+		{"", "PDC-Inventory", "prod", "PDC-Inventory-prod"},
+		{"", "PDC-Inventory", "", "PDC-Inventory"},
+		{"", "", "prod", defaultTableName},
+	}
+	for _, tt := range tests {
+		t.Setenv("TABLE_NAME", tt.tableName)
+		t.Setenv("TABLE_BASE_NAME", tt.base)
+		t.Setenv("ENVIRONMENT", tt.env)
+		if got := resolveTableName(); got != tt.want {
+			t.Errorf("TABLE_NAME=%q TABLE_BASE_NAME=%q ENVIRONMENT=%q gave %q, want %q", tt.tableName, tt.base, tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestCacheControlMaxAge(t *testing.T) {
+	setGlobal(t, &cacheMaxAge, 60)
+	tests := []struct {
+		target  string
+		want    int
+		wantErr bool
+	}{
+		{"/donuts", 60, false},
+		{"/donuts?maxAge=0", 0, false},
+		{"/donuts?maxAge=86400", 86400, false},
+		{"/donuts?maxAge=86401", 0, true},
+		{"/donuts?maxAge=-1", 0, true},
+		{"/donuts?maxAge=soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := cacheControlMaxAge(httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s gave %d, %v, want %d (error: %v)", tt.target, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSetCacheControl(t *testing.T) {
+	rec := httptest.NewRecorder()
+	setCacheControl(rec, 0)
+	if _, ok := rec.Header()["Cache-Control"]; ok {
+		t.Error("max-age 0 should not send Cache-Control")
+	}
+	setCacheControl(rec, 300)
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=300" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+}
+
+func TestCacheControlOnlyOnSuccess(t *testing.T) {
+	setGlobal(t, &cacheMaxAge, 60)
+	useFakeDynamo(t, &fakeDynamo{
+		getItem: func(_ context.Context, in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			if in.Key["ItemID"].(*types.AttributeValueMemberS).Value == "1" {
+				return &dynamodb.GetItemOutput{Item: donutItem("1", "Glazed")}, nil
+			}
+			return &dynamodb.GetItemOutput{}, nil
+		},
+	})
+
+	if rec := get(t, donutByIdHandler, "/donuts?id=1&maxAge=120"); rec.Header().Get("Cache-Control") != "public, max-age=120" {
+		t.Errorf("found donut Cache-Control = %q, want the ?maxAge override", rec.Header().Get("Cache-Control"))
+	}
+	if rec := get(t, donutByIdHandler, "/donuts?id=2"); rec.Code != 404 || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("404 got Cache-Control %q, errors should never be cached", rec.Header().Get("Cache-Control"))
+	}
+	if rec := get(t, donutByIdHandler, "/donuts?id=1&maxAge=forever"); rec.Code != 400 {
+		t.Errorf("bad maxAge status = %d, want 400", rec.Code)
+	}
+}
+
+func TestAllDonutsEmptyAs204(t *testing.T) {
+	setGlobal(t, &emptyAs204, true)
+	useFakeDynamo(t, &fakeDynamo{})
+
+	rec := get(t, allDonutsHandler, "/all_donuts")
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("got %d %q, want an empty 204", rec.Code, rec.Body.String())
+	}
+
+	useFakeDynamo(t, &fakeDynamo{scan: scanPages([]map[string]types.AttributeValue{donutItem("1", "Glazed")})})
+	if rec := get(t, allDonutsHandler, "/all_donuts"); rec.Code != 200 {
+		t.Errorf("non-empty table status = %d, want 200", rec.Code)
+	}
+}
+
+func TestLogQueryDurationSlowWarning(t *testing.T) {
+	setGlobal(t, &slowQueryThreshold, 500*time.Millisecond)
+	logs := captureLog(t)
+
+	logQueryDuration("scan", 3, 100*time.Millisecond)
+	if logs.Len() != 0 {
+		t.Errorf("fast request was logged as a warning: %q", logs)
+	}
+	logQueryDuration("scan", 3, 800*time.Millisecond)
+	if !strings.Contains(logs.String(), "WARNING: slow scan request took 800ms (threshold 500ms), 3 items") {
+		t.Errorf("slow request log = %q", logs)
+	}
+
+	logs.Reset()
+	setGlobal(t, &slowQueryThreshold, 0)
+	logQueryDuration("scan", 3, time.Hour)
+	if logs.Len() != 0 {
+		t.Errorf("with no threshold nothing should be a warning, got %q", logs)
+	}
+}
+
+func TestCheckTableRegionHint(t *testing.T) {
+	setGlobal(t, &tableName, "PDC-Inventory")
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{&types.ResourceNotFoundException{Message: aws.String("Requested resource not found")}, "check that AWS_REGION matches"},
+		{&smithy.GenericAPIError{Code: "AccessDeniedException"}, "could not describe table PDC-Inventory in region eu-west-1"},
+	}
+	for _, tt := range tests {
+		useFakeDynamo(t, &fakeDynamo{
+			describeTable: func(_ context.Context, in *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+				if *in.TableName != "PDC-Inventory" {
+					t.Errorf("described %q, want the configured table", *in.TableName)
+				}
+				return &dynamodb.DescribeTableOutput{}, tt.err
+			},
+		})
+		logs := captureLog(t)
+
+		checkTable(context.Background(), "eu-west-1")
+		if tt.want == "" && logs.Len() != 0 {
+			t.Errorf("a found table should log nothing, got %q", logs)
+		}
+		if !strings.Contains(logs.String(), tt.want) {
+			t.Errorf("%v logged %q, want %q", tt.err, logs, tt.want)
+		}
+	}
+}
+
+func TestAllDonutsCapacityAbortIs503(t *testing.T) {
+	setGlobal(t, &scanCapacityAlarm, 8)
+	setGlobal(t, &scanCapacityAbort, true)
+	var pages atomic.Int32
+	scan := withCapacity(5, scanPages(
+		[]map[string]types.AttributeValue{donutItem("1", "Glazed")},
+		[]map[string]types.AttributeValue{donutItem("2", "Maple")},
+		[]map[string]types.AttributeValue{donutItem("3", "Jelly")},
+	))
+	useFakeDynamo(t, &fakeDynamo{scan: func(ctx context.Context, in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+		pages.Add(1)
+		return scan(ctx, in)
+	}})
+	captureLog(t)
+
+	rec := get(t, allDonutsHandler, "/all_donuts")
+	if rec.Code != 503 {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if pages.Load() != 2 {
+		t.Errorf("read %d pages, want the scan stopped on the page that went over 8 RCU", pages.Load())
+	}
+	if rec.Header().Get("Cache-Control") != "" {
+		t.Error("a 503 should not be cacheable")
+	}
+}
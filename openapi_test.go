@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openAPIHandler(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+
+	var spec map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json doesn't parse: %v", err)
+	}
+	if v, _ := spec["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		t.Errorf("openapi version = %q, want 3.x", v)
+	}
+
+	// every route registered in main should be documented, and nothing else
+	paths, _ := spec["paths"].(map[string]any)
+	routes := []string{"/health", "/all_donuts", "/donuts", "/stats", "/openapi.json"}
+	if len(paths) != len(routes) {
+		t.Errorf("spec has %d paths, want %d", len(paths), len(routes))
+	}
+	for _, route := range routes {
+		if _, ok := paths[route]; !ok {
+			t.Errorf("%s isn't in the spec", route)
+		}
+	}
+
+	for _, ref := range refs(spec) {
+		if resolve(spec, ref) == nil {
+			t.Errorf("$ref %s doesn't resolve", ref)
+		}
+	}
+}
+
+// refs collects every $ref in the document.
+func refs(v any) []string {
+	var out []string
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if s, ok := child.(string); ok && k == "$ref" {
+				out = append(out, s)
+			}
+			out = append(out, refs(child)...)
+		}
+	case []any:
+		for _, child := range v {
+			out = append(out, refs(child)...)
+		}
+	}
+	return out
+}
+
+// resolve follows a local #/a/b/c reference, nil if it points nowhere.
+func resolve(spec map[string]any, ref string) any {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var cur any = spec
+	for _, part := range strings.Split(ref[2:], "/") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	return cur
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// used when AWS_REGION isn't set and no metadata service answers (e.g. running locally)
+const fallbackRegion = "us-west-2"
+
+// how long each metadata lookup gets before we give up on it
+const metadataTimeout = 2 * time.Second
+
+// resolveRegion returns AWS_REGION if set, otherwise asks the ECS task metadata endpoint or EC2 IMDS
+// which region we're running in, and falls back to fallbackRegion if neither answers.
+func resolveRegion(ctx context.Context) string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	if uri := os.Getenv("ECS_CONTAINER_METADATA_URI_V4"); uri != "" {
+		region, err := ecsRegion(ctx, uri)
+		if err == nil {
+			fmt.Printf("Discovered region %s from ECS task metadata\n", region)
+			return region
+		}
+		fmt.Printf("ECS task metadata region lookup failed: %v\n", err)
+	}
+
+	region, err := imdsRegion(ctx)
+	if err == nil {
+		fmt.Printf("Discovered region %s from EC2 instance metadata\n", region)
+		return region
+	}
+	fmt.Printf("EC2 instance metadata region lookup failed: %v\n", err)
+
+	fmt.Printf("AWS_REGION not set and no metadata service available, using %s\n", fallbackRegion)
+	return fallbackRegion
+}
+
+func imdsRegion(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	out, err := imds.New(imds.Options{}).GetRegion(ctx, &imds.GetRegionInput{})
+	if err != nil {
+		return "", err
+	}
+	return out.Region, nil
+}
+
+// ecsRegion reads the task metadata (v4) and derives the region from the task's availability zone, e.g. us-west-2a -> us-west-2.
+func ecsRegion(ctx context.Context, uri string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri+"/task", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("task metadata returned %s", resp.Status)
+	}
+
+	var task struct {
+		AvailabilityZone string `json:"AvailabilityZone"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return "", err
+	}
+	if len(task.AvailabilityZone) < 2 {
+		return "", fmt.Errorf("no availability zone in task metadata")
+	}
+	return task.AvailabilityZone[:len(task.AvailabilityZone)-1], nil
+}
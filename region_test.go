@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func ecsMetadataServer(t *testing.T, status int, body string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/task" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEcsRegion(t *testing.T) {
+	tests := []struct {
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{200, `{"AvailabilityZone":"us-east-1b"}`, "us-east-1", false},
+		{200, `{"AvailabilityZone":""}`, "", true},
+		{500, `oops`, "", true},
+		{200, `not json`, "", true},
+	}
+	for _, tt := range tests {
+		srv := ecsMetadataServer(t, tt.status, tt.body)
+		got, err := ecsRegion(context.Background(), srv.URL)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%d %s gave %q, %v, want %q (error: %v)", tt.status, tt.body, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveRegion(t *testing.T) {
+	ecs := ecsMetadataServer(t, 200, `{"AvailabilityZone":"eu-west-1a"}`)
+	brokenECS := ecsMetadataServer(t, 500, "")
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			w.Write([]byte("token"))
+		case "/latest/dynamic/instance-identity/document":
+			w.Write([]byte(`{"region":"ap-south-1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer imds.Close()
+
+	tests := []struct {
+		name, awsRegion, ecsURI, imdsEndpoint, want string
+	}{
+		{"AWS_REGION wins", "us-east-2", ecs.URL, imds.URL, "us-east-2"},
+		{"ECS metadata", "", ecs.URL, imds.URL, "eu-west-1"},
+		{"ECS fails, IMDS", "", brokenECS.URL, imds.URL, "ap-south-1"},
+		{"nothing answers", "", "", "", fallbackRegion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", tt.awsRegion)
+			t.Setenv("ECS_CONTAINER_METADATA_URI_V4", tt.ecsURI)
+			if tt.imdsEndpoint != "" {
+				t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", tt.imdsEndpoint)
+			} else {
+				t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+			}
+			if got := resolveRegion(context.Background()); got != tt.want {
+				t.Errorf("resolveRegion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
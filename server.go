@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// number of requests currently being handled, reported while draining on shutdown
+var inFlight atomic.Int64
+
+func withInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newServer wraps handler in the middleware every route gets and applies the server timeouts.
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":8080",
+		Handler:           withInFlight(withStats(withCORS(handler))), // CORS outermost so redirects, 429s and preflights all get the headers
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      envDuration("WRITE_TIMEOUT", 30*time.Second), // a client that stops reading can't hold a handler forever
+	}
+}
+
+// runServer serves until SIGINT/SIGTERM (ECS sends SIGTERM when stopping a task), then stops accepting
+// connections and waits up to shutdownTimeout for in-flight requests before force closing.
+func runServer(srv *http.Server, shutdownTimeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Printf("Shutting down, %d requests in flight, waiting up to %s\n", inFlight.Load(), shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("WARNING: shutdown deadline hit with %d requests still in flight, closing connections", inFlight.Load())
+		return srv.Close()
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	fmt.Println("Server stopped cleanly")
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startServer runs handler through runServer on a free local port and waits until it's accepting connections.
+func startServer(t *testing.T, handler http.Handler, shutdownTimeout time.Duration) (string, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := &http.Server{Addr: addr, Handler: withInFlight(handler)}
+	done := make(chan error, 1)
+	go func() { done <- runServer(srv, shutdownTimeout) }()
+
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return "http://" + addr, done
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server never started listening on %s", addr)
+	return "", nil
+}
+
+func TestRunServerDrainsInFlightRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	url, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}), 5*time.Second)
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	got := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		got <- result{status: resp.StatusCode, body: string(body)}
+	}()
+
+	<-started
+	syscall.Kill(os.Getpid(), syscall.SIGTERM) // what ECS sends when stopping the task
+	time.Sleep(50 * time.Millisecond)          // let Shutdown start before the request finishes
+	close(release)
+
+	if r := <-got; r.err != nil || r.status != 200 || r.body != "done" {
+		t.Errorf("in-flight request got %+v, want it to finish with 200", r)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("runServer = %v, want a clean stop", err)
+	}
+}
+
+func TestRunServerShutdownTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	url, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}), 50*time.Millisecond)
+
+	clientErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		clientErr <- err
+	}()
+	<-started
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServer didn't give up on a stuck request after SHUTDOWN_TIMEOUT")
+	}
+	if err := <-clientErr; err == nil {
+		t.Error("the stuck request's connection should have been closed")
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	t.Setenv("WRITE_TIMEOUT", "")
+	if got := envDuration("WRITE_TIMEOUT", 30*time.Second); got != 30*time.Second {
+		t.Errorf("unset = %s, want the default", got)
+	}
+	t.Setenv("WRITE_TIMEOUT", "250ms")
+	if got := envDuration("WRITE_TIMEOUT", 30*time.Second); got != 250*time.Millisecond {
+		t.Errorf("250ms = %s", got)
+	}
+}
+
+func TestNewServerWriteTimeout(t *testing.T) {
+	t.Setenv("WRITE_TIMEOUT", "")
+	if got := newServer(http.NotFoundHandler()).WriteTimeout; got != 30*time.Second {
+		t.Errorf("default WriteTimeout = %s, want 30s", got)
+	}
+
+	t.Setenv("WRITE_TIMEOUT", "100ms")
+	writeErr := make(chan error, 1)
+	srv := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 64<<10)
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	}))
+	if srv.WriteTimeout != 100*time.Millisecond {
+		t.Fatalf("WriteTimeout = %s, want WRITE_TIMEOUT's 100ms", srv.WriteTimeout)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// a client that sends a request and never reads the response
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /all_donuts HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	select {
+	case <-writeErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler is still stuck writing to a client that stopped reading")
+	}
+}
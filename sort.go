@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// donutSort is the DEFAULT_SORT setting, e.g. "itemId" or "name:desc". Scan order isn't stable,
+// so this makes /all_donuts come back in the same order every time.
+type donutSort struct {
+	field string // "itemId" or "name", empty means leave scan order alone
+	desc  bool
+}
+
+var defaultSort donutSort
+
+func parseDonutSort(v string) (donutSort, error) {
+	if v == "" {
+		return donutSort{}, nil
+	}
+	field, order, _ := strings.Cut(v, ":")
+	if field != "itemId" && field != "name" {
+		return donutSort{}, fmt.Errorf("unknown sort field %q, expected itemId or name", field)
+	}
+	if order != "" && order != "asc" && order != "desc" {
+		return donutSort{}, fmt.Errorf("unknown sort order %q, expected asc or desc", order)
+	}
+	return donutSort{field: field, desc: order == "desc"}, nil
+}
+
+func (s donutSort) apply(donuts []Donut) {
+	if s.field == "" {
+		return
+	}
+	numeric := numericItemIDs(donuts)
+	sort.SliceStable(donuts, func(i, j int) bool {
+		a, b := donuts[i], donuts[j]
+		var less, greater bool
+		// donuts with the same name fall back to itemId, otherwise they'd keep the unstable scan order
+		if s.field == "itemId" || a.Name == b.Name {
+			less, greater = lessItemID(a.ItemId, b.ItemId, numeric), lessItemID(b.ItemId, a.ItemId, numeric)
+		} else {
+			less, greater = a.Name < b.Name, b.Name < a.Name
+		}
+		if s.desc {
+			return greater
+		}
+		return less
+	})
+}
+
+// numericItemIDs reports whether every id is a number. The choice has to be made for the whole slice,
+// mixing numeric and string comparisons pair by pair isn't transitive ("2" < "10" < "1a" < "2").
+func numericItemIDs(donuts []Donut) bool {
+	for _, d := range donuts {
+		if _, err := strconv.Atoi(d.ItemId); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lessItemID compares ids numerically when numeric is set so "2" sorts before "10", otherwise as strings.
+func lessItemID(a, b string, numeric bool) bool {
+	if numeric {
+		an, _ := strconv.Atoi(a)
+		bn, _ := strconv.Atoi(b)
+		return an < bn
+	}
+	return a < b
+}
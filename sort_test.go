@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDonutSort(t *testing.T) {
+	tests := []struct {
+		in   string
+		want donutSort
+	}{
+		{"", donutSort{}},
+		{"itemId", donutSort{field: "itemId"}},
+		{"name:asc", donutSort{field: "name"}},
+		{"name:desc", donutSort{field: "name", desc: true}},
+	}
+	for _, tt := range tests {
+		got, err := parseDonutSort(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseDonutSort(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"price", "name:up"} {
+		if _, err := parseDonutSort(bad); err == nil {
+			t.Errorf("parseDonutSort(%q) should fail", bad)
+		}
+	}
+}
+
+func TestLessItemID(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		numeric bool
+		want    bool
+	}{
+		{"2", "10", true, true},
+		{"10", "2", true, false},
+		{"2", "10", false, false},
+		{"a", "b", false, true},
+	}
+	for _, tt := range tests {
+		if got := lessItemID(tt.a, tt.b, tt.numeric); got != tt.want {
+			t.Errorf("lessItemID(%q, %q, %v) = %v, want %v", tt.a, tt.b, tt.numeric, got, tt.want)
+		}
+	}
+}
+
+func TestDonutSortMixedIDs(t *testing.T) {
+	// one non-numeric id makes the whole list sort as strings, in any starting order
+	want := []string{"10", "1a", "2"}
+	for _, start := range [][]string{{"2", "10", "1a"}, {"1a", "2", "10"}, {"10", "1a", "2"}} {
+		donuts := make([]Donut, len(start))
+		for i, id := range start {
+			donuts[i] = Donut{ItemId: id}
+		}
+		donutSort{field: "itemId"}.apply(donuts)
+		if got := ids(donuts); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v sorted to %v, want %v", start, got, want)
+		}
+	}
+}
+
+func ids(donuts []Donut) []string {
+	out := make([]string, len(donuts))
+	for i, d := range donuts {
+		out[i] = d.ItemId
+	}
+	return out
+}
+
+func TestDonutSortApply(t *testing.T) {
+	tests := []struct {
+		sort donutSort
+		want []string
+	}{
+		{donutSort{}, []string{"10", "2", "3", "1"}},
+		{donutSort{field: "itemId"}, []string{"1", "2", "3", "10"}},
+		{donutSort{field: "itemId", desc: true}, []string{"10", "3", "2", "1"}},
+		// 10 and 2 are both Glazed, so they're ordered by id
+		{donutSort{field: "name"}, []string{"2", "10", "3", "1"}},
+		{donutSort{field: "name", desc: true}, []string{"1", "3", "10", "2"}},
+	}
+	for _, tt := range tests {
+		donuts := []Donut{
+			{ItemId: "10", Name: "Glazed"},
+			{ItemId: "2", Name: "Glazed"},
+			{ItemId: "3", Name: "Jelly"},
+			{ItemId: "1", Name: "Maple"},
+		}
+		tt.sort.apply(donuts)
+		if got := ids(donuts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v sorted to %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestDonutSortNameTiesAreDeterministic(t *testing.T) {
+	s := donutSort{field: "name"}
+	a := []Donut{{ItemId: "2", Name: "Glazed"}, {ItemId: "1", Name: "Glazed"}}
+	b := []Donut{{ItemId: "1", Name: "Glazed"}, {ItemId: "2", Name: "Glazed"}}
+	s.apply(a)
+	s.apply(b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("same donuts in a different scan order sorted differently: %v vs %v", a, b)
+	}
+}
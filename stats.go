@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how many of the most recent request latencies the percentiles are computed over
+const statsWindowSize = 1000
+
+// requestStats holds in-process counters for the /stats endpoint. The counters are atomics so they can be
+// bumped from any goroutine without locking, only the latency ring buffer needs the mutex.
+type requestStats struct {
+	total       atomic.Int64
+	errors      atomic.Int64
+	dynamoCalls atomic.Int64
+
+	mu        sync.Mutex
+	latencies [statsWindowSize]time.Duration // ring buffer of the latest latencies
+	next      int                            // index the next latency is written to
+	count     int                            // how many slots of latencies are filled
+}
+
+var stats = &requestStats{}
+
+func (s *requestStats) recordRequest(latency time.Duration, status int) {
+	s.total.Add(1)
+	if status >= 500 {
+		s.errors.Add(1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies[s.next] = latency
+	s.next = (s.next + 1) % statsWindowSize
+	if s.count < statsWindowSize {
+		s.count++
+	}
+}
+
+func (s *requestStats) recordDynamoCall() {
+	s.dynamoCalls.Add(1)
+}
+
+type statsSnapshot struct {
+	TotalRequests int64   `json:"totalRequests"`
+	ErrorRate     float64 `json:"errorRate"`
+	P50Ms         float64 `json:"p50Ms"`
+	P95Ms         float64 `json:"p95Ms"`
+	DynamoDBCalls int64   `json:"dynamodbCalls"`
+}
+
+func (s *requestStats) snapshot() statsSnapshot {
+	// read errors before total so a request landing in between can't push the rate over 1
+	errCount := s.errors.Load()
+	snap := statsSnapshot{TotalRequests: s.total.Load(), DynamoDBCalls: s.dynamoCalls.Load()}
+	if snap.TotalRequests > 0 {
+		snap.ErrorRate = float64(errCount) / float64(snap.TotalRequests)
+	}
+
+	s.mu.Lock()
+	window := make([]time.Duration, s.count)
+	copy(window, s.latencies[:s.count])
+	s.mu.Unlock()
+
+	// sort outside the lock so a /stats call doesn't hold up requests being recorded
+	sort.Slice(window, func(i, j int) bool { return window[i] < window[j] })
+	snap.P50Ms = percentileMs(window, 0.50)
+	snap.P95Ms = percentileMs(window, 0.95)
+	return snap
+}
+
+// percentileMs uses the nearest-rank method on an already sorted slice.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1 // nearest rank is ceil(p*n), 1-based
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+// statusRecorder remembers the status code a handler wrote so the middleware can count errors.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// withStats times every request going through the mux and records it in stats.
+func withStats(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		latency := time.Since(start)
+		stats.recordRequest(latency, rec.status)
+
+		errorCount := 0.0
+		if rec.status >= 500 {
+			errorCount = 1
+		}
+		emitEMF(map[string]string{"Path": metricPath(r.URL.Path)},
+			emfMetric{Name: "Requests", Unit: "Count", Value: 1},
+			emfMetric{Name: "Errors", Unit: "Count", Value: errorCount},
+			emfMetric{Name: "Latency", Unit: "Milliseconds", Value: float64(latency) / float64(time.Millisecond)},
+		)
+	})
+}
+
+// metricPath keeps the Path dimension to our registered routes, so random 404 paths can't create unlimited metrics.
+// withStats runs before the trailing slash is stripped, so /donuts/ is cleaned here to count as /donuts.
+func metricPath(p string) string {
+	p = strings.TrimSuffix(path.Clean(p), "/")
+	switch p {
+	case "/health", "/all_donuts", "/donuts", "/stats", "/openapi.json":
+		return p
+	}
+	return "other"
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats.snapshot())
+}
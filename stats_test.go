@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// run with -race: counters and the latency ring are written from many goroutines while /stats reads them
+func TestStatsConcurrentConsistency(t *testing.T) {
+	s := &requestStats{}
+	const workers, perWorker = 16, 500
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				status := 200
+				if j%5 == 0 {
+					status = 500
+				}
+				s.recordRequest(time.Duration(j)*time.Millisecond, status)
+				s.recordDynamoCall()
+			}
+		}(i)
+	}
+	stop := make(chan struct{})
+	snaps := make(chan struct{})
+	go func() {
+		defer close(snaps)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if snap := s.snapshot(); snap.ErrorRate > 1 {
+				t.Errorf("error rate over 1 mid-run: %+v", snap)
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-snaps
+
+	snap := s.snapshot()
+	if snap.TotalRequests != workers*perWorker || snap.DynamoDBCalls != workers*perWorker {
+		t.Errorf("counts = %+v, want %d of each", snap, workers*perWorker)
+	}
+	if snap.ErrorRate != 0.2 {
+		t.Errorf("error rate = %v, want 0.2", snap.ErrorRate)
+	}
+	if s.count != statsWindowSize {
+		t.Errorf("latency window holds %d, want %d", s.count, statsWindowSize)
+	}
+}
+
+func TestPercentileMs(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		in   []time.Duration
+		p    float64
+		want float64
+	}{
+		{nil, 0.5, 0},
+		{sorted[:1], 0.95, 1},
+		{sorted, 0.50, 50},
+		{sorted, 0.95, 95},
+		{sorted, 1, 100},
+		{sorted, 0, 1},
+		// ceil(0.95*34) = 33, rounding would pick the 32nd value
+		{sorted[:34], 0.95, 33},
+		{sorted[:34], 0.50, 17},
+		{sorted[:3], 0.50, 2},
+	}
+	for _, tt := range tests {
+		if got := percentileMs(tt.in, tt.p); got != tt.want {
+			t.Errorf("percentileMs(%d items, %v) = %v, want %v", len(tt.in), tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := &requestStats{}
+	if snap := s.snapshot(); snap != (statsSnapshot{}) {
+		t.Errorf("empty stats = %+v, want zeros", snap)
+	}
+
+	// record out of order, the snapshot sorts its copy of the window
+	for _, ms := range []int{30, 10, 40, 20} {
+		s.recordRequest(time.Duration(ms)*time.Millisecond, 200)
+	}
+	s.recordRequest(50*time.Millisecond, 503)
+	s.recordDynamoCall()
+
+	want := statsSnapshot{TotalRequests: 5, ErrorRate: 0.2, P50Ms: 30, P95Ms: 50, DynamoDBCalls: 1}
+	if snap := s.snapshot(); snap != want {
+		t.Errorf("snapshot = %+v, want %+v", snap, want)
+	}
+}
+
+func TestSnapshotWindowDropsOldLatencies(t *testing.T) {
+	s := &requestStats{}
+	for i := 0; i < statsWindowSize; i++ {
+		s.recordRequest(time.Second, 200)
+	}
+	for i := 0; i < statsWindowSize; i++ {
+		s.recordRequest(time.Millisecond, 200)
+	}
+	if snap := s.snapshot(); snap.P95Ms != 1 || snap.TotalRequests != 2*statsWindowSize {
+		t.Errorf("snapshot = %+v, want percentiles over only the latest %d requests", snap, statsWindowSize)
+	}
+}
+
+func TestMetricPath(t *testing.T) {
+	tests := map[string]string{
+		"/donuts":           "/donuts",
+		"/donuts/":          "/donuts",
+		"/all_donuts/":      "/all_donuts",
+		"//all_donuts":      "/all_donuts",
+		"/health":           "/health",
+		"/":                 "other",
+		"/random/404/path":  "other",
+		"/donuts/../secret": "other",
+	}
+	for in, want := range tests {
+		if got := metricPath(in); got != want {
+			t.Errorf("metricPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
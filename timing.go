@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serverTiming collects per-phase durations for ?debug=true, reported in a Server-Timing header
+// (browser dev tools show these in the network tab).
+type serverTiming struct {
+	enabled bool
+	phases  []string
+}
+
+func newServerTiming(r *http.Request) *serverTiming {
+	return &serverTiming{enabled: r.URL.Query().Get("debug") == "true"}
+}
+
+// since records the time from start until now as the named phase.
+func (t *serverTiming) since(name string, start time.Time) {
+	if !t.enabled {
+		return
+	}
+	ms := float64(time.Since(start)) / float64(time.Millisecond)
+	t.phases = append(t.phases, fmt.Sprintf("%s;dur=%.3f", name, ms))
+}
+
+// writeJSON encodes v as the response. With timing enabled the body is encoded into a buffer first,
+// so the encode phase can go in the Server-Timing header before anything is sent.
+func writeJSON(w http.ResponseWriter, v any, t *serverTiming) error {
+	w.Header().Set("Content-Type", "application/json")
+	if !t.enabled {
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	start := time.Now()
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	t.since("encode", start)
+
+	w.Header().Set("Server-Timing", strings.Join(t.phases, ", "))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
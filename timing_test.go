@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var serverTimingPhase = regexp.MustCompile(`^(\w+);dur=(\d+\.\d{3})$`)
+
+// parseServerTiming returns the phase names in order, failing the test on a malformed or negative entry.
+func parseServerTiming(t *testing.T, header string) []string {
+	t.Helper()
+	var names []string
+	for _, phase := range strings.Split(header, ", ") {
+		m := serverTimingPhase.FindStringSubmatch(phase)
+		if m == nil {
+			t.Fatalf("malformed Server-Timing entry %q in %q", phase, header)
+		}
+		if d, _ := strconv.ParseFloat(m[2], 64); d < 0 {
+			t.Errorf("%s has a negative duration", m[1])
+		}
+		names = append(names, m[1])
+	}
+	return names
+}
+
+func TestServerTimingOnDebug(t *testing.T) {
+	useFakeDynamo(t, &fakeDynamo{
+		scan: scanPages([]map[string]types.AttributeValue{donutItem("1", "Glazed")}),
+		getItem: func(context.Context, *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: donutItem("1", "Glazed")}, nil
+		},
+	})
+
+	for _, tt := range []struct {
+		handler http.HandlerFunc
+		target  string
+	}{
+		{allDonutsHandler, "/all_donuts?debug=true"},
+		{donutByIdHandler, "/donuts?id=1&debug=true"},
+	} {
+		rec := get(t, tt.handler, tt.target)
+		got := strings.Join(parseServerTiming(t, rec.Header().Get("Server-Timing")), ",")
+		if got != "dynamodb,transform,encode" {
+			t.Errorf("%s phases = %s, want dynamodb,transform,encode", tt.target, got)
+		}
+		if !strings.Contains(rec.Body.String(), `"itemId":"1"`) {
+			t.Errorf("%s body = %s", tt.target, rec.Body.String())
+		}
+	}
+}
+
+func TestServerTimingOffByDefault(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := writeJSON(rec, []Donut{}, newServerTiming(httptest.NewRequest(http.MethodGet, "/all_donuts", nil))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.Header()["Server-Timing"]; ok {
+		t.Errorf("Server-Timing sent without ?debug=true: %q", rec.Header().Get("Server-Timing"))
+	}
+	if rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != "[]\n" {
+		t.Errorf("got %q %q", rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}